@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gobuild/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/DKnight1900/fswatch/supervisor"
+)
+
+// rootCmd is the cobra entry point, replacing the old flag+flag.Arg(0)
+// dispatch. Every subcommand also reads FSWATCH_-prefixed env vars for
+// its flags (see bindEnv), mirroring how most cobra-based CLIs let env
+// override flags without editing YAML.
+var rootCmd = &cobra.Command{
+	Use:     "fswatch",
+	Short:   "Watch the filesystem and run commands on change",
+	Version: VERSION,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		bindEnv(cmd, "FSWATCH")
+		statusR = NewStatusRenderer(flagLogFormat, flagNoConsole)
+		return nil
+	},
+}
+
+var (
+	flagConfig    string
+	flagOnly      string
+	flagDryRun    bool
+	flagNoConsole bool
+	flagLogFormat string
+	flagCtlAddr   string
+	flagCtlToken  string
+
+	flagCtlClientAddr  string
+	flagCtlClientToken string
+)
+
+var startCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start watching using a .fsw.yml/.fsw.json config",
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		bindEnv(cmd, "FSWATCH")
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		paths := []string{FWCONFIG_JSON, FWCONFIG_YAML}
+		if flagConfig != "" {
+			paths = []string{flagConfig}
+		}
+		configPath, err := resolveFWConfigPath(paths...)
+		if err != nil {
+			return err
+		}
+		fwc, err := readFWConfig(configPath)
+		if err != nil {
+			return err
+		}
+		var only []string
+		if flagOnly != "" {
+			only = strings.Split(flagOnly, ",")
+		}
+		return runFWConfig(fwc, configPath, only, flagDryRun, flagCtlAddr, flagCtlToken)
+	},
+}
+
+var runCmd = &cobra.Command{
+	Use:                "run -- <cmd>",
+	Short:              "Watch the current directory and run an ad-hoc command, no config file needed",
+	Args:               cobra.MinimumNArgs(1),
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+		command := strings.Join(args, " ")
+		fwc, err := fixFWConfig(FWConfig{
+			Description: "ad-hoc run via `fswatch run`",
+			Triggers: []supervisor.TriggerConfig{{
+				Name:    "run",
+				Pattens: []string{"**/*"},
+				Command: command,
+			}},
+		})
+		if err != nil {
+			return err
+		}
+		return runFWConfig(fwc, "", nil, false, "", "")
+	},
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Interactively generate a .fsw.yml/.fsw.json config",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		InitFWConfig()
+		return nil
+	},
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Parse the config file and report errors without starting",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		paths := []string{FWCONFIG_JSON, FWCONFIG_YAML}
+		if flagConfig != "" {
+			paths = []string{flagConfig}
+		}
+		fwc, err := readFWConfig(paths...)
+		if err != nil {
+			return err
+		}
+		CPrintf(CGREEN, "config OK, %d trigger(s)", len(fwc.Triggers))
+		return nil
+	},
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the triggers defined in the config file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		paths := []string{FWCONFIG_JSON, FWCONFIG_YAML}
+		if flagConfig != "" {
+			paths = []string{flagConfig}
+		}
+		fwc, err := readFWConfig(paths...)
+		if err != nil {
+			return err
+		}
+		for _, tg := range fwc.Triggers {
+			fmt.Printf("%s\t%s\t%v\n", tg.Name, tg.Command, tg.Pattens)
+		}
+		return nil
+	},
+}
+
+func init() {
+	startCmd.Flags().StringVar(&flagConfig, "config", "", "path to .fsw.yml/.fsw.json (default: auto-detect in cwd)")
+	startCmd.Flags().StringVar(&flagOnly, "only", "", "comma-separated list of trigger names to run")
+	startCmd.Flags().BoolVar(&flagDryRun, "dry-run", false, "print what would run instead of starting triggers")
+	startCmd.Flags().StringVar(&flagCtlAddr, "ctl-addr", "", "listen address for the control API, e.g. unix:///tmp/fswatch.sock or tcp://127.0.0.1:7070 (default: disabled)")
+	startCmd.Flags().StringVar(&flagCtlToken, "ctl-token", "", "bearer token required on every control API request (recommended for tcp:// ctl-addr)")
+
+	validateCmd.Flags().StringVar(&flagConfig, "config", "", "path to .fsw.yml/.fsw.json (default: auto-detect in cwd)")
+	listCmd.Flags().StringVar(&flagConfig, "config", "", "path to .fsw.yml/.fsw.json (default: auto-detect in cwd)")
+
+	rootCmd.PersistentFlags().BoolVar(&flagNoConsole, "no-console", false, "use the plain line-based status renderer instead of the live TTY panel")
+	rootCmd.PersistentFlags().StringVar(&flagLogFormat, "log-format", "", "status output format: \"\" (auto), \"console\", or \"json\" (newline-delimited)")
+
+	ctlCmd.PersistentFlags().StringVar(&flagCtlClientAddr, "ctl-addr", defaultCtlAddr, "address of a running fswatch's control API")
+	ctlCmd.PersistentFlags().StringVar(&flagCtlClientToken, "ctl-token", "", "bearer token, if the server requires one")
+	ctlCmd.AddCommand(ctlListCmd, ctlRunCmd, ctlStopCmd, ctlEventsCmd)
+
+	rootCmd.AddCommand(startCmd, runCmd, initCmd, validateCmd, listCmd, ctlCmd)
+}
+
+// bindEnv lets every flag of cmd also be set via PREFIX_FLAG_NAME (dashes
+// become underscores), so e.g. --only can be overridden with
+// FSWATCH_ONLY without touching the YAML or the invocation.
+func bindEnv(cmd *cobra.Command, prefix string) {
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			return
+		}
+		envName := prefix + "_" + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		if val, ok := os.LookupEnv(envName); ok {
+			f.Value.Set(val)
+		}
+	})
+}
+
+// Execute runs the root command, printing any error and exiting non-zero
+// the way a hand-rolled main() used to via log.Fatal.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package ctl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/DKnight1900/fswatch/supervisor"
+)
+
+// Client talks to a Server over the address it listens on, for use by
+// the `fswatch ctl` subcommand or any other local process.
+type Client struct {
+	http  *http.Client
+	base  string
+	token string
+}
+
+// NewClient builds a Client for addr ("unix:///path/to.sock" or
+// "tcp://host:port", defaulting like NewServer when addr is empty). The
+// underlying HTTP transport dials addr's network directly, so "base" is
+// just a fixed placeholder host the client always connects through.
+// token is sent as a Bearer token and must match the Server's, if one
+// was configured there.
+func NewClient(addr, token string) (*Client, error) {
+	network, address, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: defaultDialTimeout}
+			return d.DialContext(ctx, network, address)
+		},
+	}
+	return &Client{
+		http:  &http.Client{Transport: transport},
+		base:  "http://ctl",
+		token: token,
+	}, nil
+}
+
+// authorize sets req's Authorization header when the client has a token.
+func (c *Client) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
+
+// List returns every trigger currently running on the server.
+func (c *Client) List() ([]supervisor.TriggerConfig, error) {
+	req, err := http.NewRequest("GET", c.base+"/triggers", nil)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	var out []supervisor.TriggerConfig
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Run forces the named trigger to stop and restart immediately.
+func (c *Client) Run(name string) error {
+	return c.post("/triggers/run", name)
+}
+
+// Stop stops the named trigger.
+func (c *Client) Stop(name string) error {
+	return c.post("/triggers/stop", name)
+}
+
+func (c *Client) post(path, name string) error {
+	u := fmt.Sprintf("%s%s?name=%s", c.base, path, url.QueryEscape(name))
+	req, err := http.NewRequest("POST", u, nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+// checkStatus turns a non-2xx response into an error carrying the
+// server's message, instead of leaving the caller to decode an error
+// page (e.g. the 401 body from authMiddleware) as if it were JSON.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("ctl: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// Events streams lifecycle events until ctx is cancelled or the server
+// closes the connection, calling onEvent for each one in arrival order.
+func (c *Client) Events(ctx context.Context, onEvent func(supervisor.Event)) error {
+	req, err := http.NewRequest("GET", c.base+"/events", nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	req = req.WithContext(ctx)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var evt supervisor.Event
+		if err := dec.Decode(&evt); err != nil {
+			return err
+		}
+		onEvent(evt)
+	}
+}
+
+// defaultDialTimeout bounds how long a client waits to connect to a
+// stale or unreachable unix socket, e.g. one left over from a crashed
+// `fswatch start`, instead of hanging indefinitely.
+const defaultDialTimeout = 3 * time.Second
@@ -0,0 +1,180 @@
+// Package ctl is fswatch's local control API: a small HTTP+JSON server
+// a running `fswatch start` can expose so another process (an editor
+// plugin, a CI hook, the `fswatch ctl` subcommand itself) can list,
+// force-run, or stop triggers, and stream their lifecycle events,
+// without attaching to the watcher's stdout. It listens on a unix
+// socket by default, since that's all a single-machine dev loop needs;
+// a tcp:// address works the same way for a remote host.
+package ctl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/DKnight1900/fswatch/supervisor"
+)
+
+// DefaultAddr is the ctl address NewServer and NewClient use when the
+// caller passes an empty addr, so `fswatch start` and `fswatch ctl` agree
+// on where the control API lives without either side hard-coding it.
+const DefaultAddr = "unix:///tmp/fswatch.sock"
+
+// Server exposes a Supervisor over HTTP+JSON on a unix or tcp listener.
+type Server struct {
+	ln    net.Listener
+	sup   *supervisor.Supervisor
+	mux   *http.ServeMux
+	token string
+}
+
+// NewServer parses addr ("unix:///path/to.sock" or "tcp://host:port",
+// defaulting to unix:///tmp/fswatch.sock when addr is empty) and binds
+// its listener. Call Serve to start accepting, Close to shut down.
+//
+// token, if non-empty, is required as a Bearer token on every request;
+// it's meant for a tcp:// addr reachable off the local machine; a unix
+// socket is already restricted by filesystem permissions, but a token is
+// honored there too if one is set.
+func NewServer(addr, token string, sup *supervisor.Supervisor) (*Server, error) {
+	network, address, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	if network == "unix" {
+		if unixSocketLive(address) {
+			return nil, fmt.Errorf("ctl: %s is already in use by another fswatch", address)
+		}
+		os.Remove(address) // a stale socket from a previous crash shouldn't block bind
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{ln: ln, sup: sup, mux: http.NewServeMux(), token: token}
+	s.mux.HandleFunc("/triggers", s.handleTriggers)
+	s.mux.HandleFunc("/triggers/run", s.handleRun)
+	s.mux.HandleFunc("/triggers/stop", s.handleStop)
+	s.mux.HandleFunc("/events", s.handleEvents)
+	return s, nil
+}
+
+// unixSocketLive reports whether address is a unix socket something is
+// actively listening on, so NewServer can tell a live control API (don't
+// steal it) from a stale file left behind by a crash (safe to remove).
+func unixSocketLive(address string) bool {
+	conn, err := net.DialTimeout("unix", address, 200*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// parseAddr splits a ctl address into the net.Listen network/address
+// pair, e.g. "unix:///tmp/fswatch.sock" -> ("unix", "/tmp/fswatch.sock").
+func parseAddr(addr string) (network, address string, err error) {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		return "unix", strings.TrimPrefix(addr, "unix://"), nil
+	case strings.HasPrefix(addr, "tcp://"):
+		return "tcp", strings.TrimPrefix(addr, "tcp://"), nil
+	default:
+		return "", "", fmt.Errorf("ctl addr %q: must start with unix:// or tcp://", addr)
+	}
+}
+
+// Serve blocks accepting connections until Close is called.
+func (s *Server) Serve() error {
+	return http.Serve(s.ln, s.authMiddleware(s.mux))
+}
+
+// authMiddleware rejects requests missing "Authorization: Bearer
+// <token>" when a token is configured; it's a no-op otherwise.
+func (s *Server) authMiddleware(next http.Handler) http.Handler {
+	if s.token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+s.token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Close stops accepting connections and removes the unix socket file,
+// if any, so a clean shutdown doesn't leave a stale path behind.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	if addr, ok := s.ln.Addr().(*net.UnixAddr); ok {
+		os.Remove(addr.Name)
+	}
+	return err
+}
+
+func (s *Server) handleTriggers(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(s.sup.List())
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if !s.sup.RunTrigger(name) {
+		http.Error(w, fmt.Sprintf("no such trigger %q", name), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleStop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	s.sup.Stop(name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleEvents streams every lifecycle event as newline-delimited JSON
+// for as long as the client stays connected, the same format the CLI's
+// own --log-format=json uses.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	sub := s.sup.Subscribe()
+	defer s.sup.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case evt, ok := <-sub:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(evt); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
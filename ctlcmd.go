@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/DKnight1900/fswatch/ctl"
+	"github.com/DKnight1900/fswatch/supervisor"
+)
+
+// defaultCtlAddr is where `fswatch ctl` looks for a running fswatch's
+// control API when --ctl-addr isn't given. It's just ctl.DefaultAddr
+// spelled out so cobra's flag help can show it directly.
+const defaultCtlAddr = ctl.DefaultAddr
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Talk to a running fswatch's control API (editor plugins, CI hooks, dashboards)",
+}
+
+var ctlListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the triggers currently running on the server",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := ctl.NewClient(flagCtlClientAddr, flagCtlClientToken)
+		if err != nil {
+			return err
+		}
+		triggers, err := c.List()
+		if err != nil {
+			return err
+		}
+		for _, tg := range triggers {
+			fmt.Printf("%s\t%s\n", tg.Name, tg.Command)
+		}
+		return nil
+	},
+}
+
+var ctlRunCmd = &cobra.Command{
+	Use:   "run <trigger>",
+	Short: "Force a trigger to stop and restart immediately, bypassing the fs debounce",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := ctl.NewClient(flagCtlClientAddr, flagCtlClientToken)
+		if err != nil {
+			return err
+		}
+		return c.Run(args[0])
+	},
+}
+
+var ctlStopCmd = &cobra.Command{
+	Use:   "stop <trigger>",
+	Short: "Stop a running trigger",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := ctl.NewClient(flagCtlClientAddr, flagCtlClientToken)
+		if err != nil {
+			return err
+		}
+		return c.Stop(args[0])
+	},
+}
+
+var ctlEventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Stream trigger lifecycle events as newline-delimited JSON until interrupted",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := ctl.NewClient(flagCtlClientAddr, flagCtlClientToken)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		sigC := make(chan os.Signal, 1)
+		signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			<-sigC
+			cancel()
+		}()
+
+		enc := json.NewEncoder(os.Stdout)
+		err = c.Events(ctx, func(evt supervisor.Event) {
+			enc.Encode(evt)
+		})
+		if err != nil && ctx.Err() == nil && err != io.EOF {
+			return err
+		}
+		return nil
+	},
+}
@@ -1,10 +1,9 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -15,13 +14,13 @@ import (
 	"strings"
 	"sync"
 	"syscall"
-	"time"
 
-	ignore "github.com/codeskyblue/dockerignore"
-	"github.com/codeskyblue/kexec"
 	"github.com/go-fsnotify/fsnotify"
 	"github.com/gobuild/log"
 	yaml "gopkg.in/yaml.v2"
+
+	"github.com/DKnight1900/fswatch/ctl"
+	"github.com/DKnight1900/fswatch/supervisor"
 )
 
 const (
@@ -72,106 +71,30 @@ func CPrintf(ansiColor string, format string, args ...interface{}) {
 	log.Printf(format, args...)
 }
 
-type TriggerEvent struct {
-	Name          string            `yaml:"name" json:"name"`
-	Pattens       []string          `yaml:"pattens" json:"pattens"`
-	matchPattens  []string          `yaml:"-" json:"-"`
-	Environ       map[string]string `yaml:"env" json:"env"`
-	Command       string            `yaml:"cmd" json:"cmd"`
-	Delay         string            `yaml:"delay" json:"delay"`
-	delayDuration time.Duration     `yaml:"-" json:"-"`
-	Signal        string            `yaml:"signal" json:"signal"`
-	killSignal    os.Signal         `yaml:"-" json:"-"`
-	kcmd          *kexec.KCommand
-}
-
-func (this *TriggerEvent) Start() error {
-	CPrintf(CGREEN, fmt.Sprintf("[%s] exec start: %s", this.Name, this.Command))
-	startTime := time.Now()
-	cmd := kexec.CommandString(this.Command)
-	env := os.Environ()
-	for key, val := range this.Environ {
-		env = append(env, fmt.Sprintf("%s=%s", key, val))
-	}
-	cmd.Env = env
-	this.kcmd = cmd
-	err := cmd.Start()
-	go func() {
-		if er := cmd.Wait(); er != nil {
-			CPrintf(CRED, "[%s] program exited: %v", this.Name, er)
-		}
-		log.Infof("[%s] finish in %s", this.Name, time.Since(startTime))
-	}()
-	return err
-}
-
-func (this *TriggerEvent) Stop() {
-	if this.kcmd != nil {
-		if this.kcmd.ProcessState != nil && this.kcmd.ProcessState.Exited() {
-			this.kcmd = nil
-			return
-		}
-		this.kcmd.Terminate(this.killSignal)
-		CPrintf(CYELLOW, "[%s] program terminated, signal(%v)", this.Name, this.killSignal)
-		this.kcmd = nil
-	}
-}
-
-// when use func (this *TriggerEvent) strange things happened, wired
-func (this *TriggerEvent) WatchEvent(evtC chan FSEvent, wg *sync.WaitGroup) {
-	this.Start()
-	for evt := range evtC {
-		isMatch, err := ignore.Matches(evt.Name, this.Pattens)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if !isMatch {
-			continue
-		}
-		this.Stop()
-		CPrintf(CGREEN, "changed: %v", evt.Name)
-		CPrintf(CGREEN, "delay: %v", this.Delay)
-		time.Sleep(this.delayDuration)
-		this.Start()
-	}
-	this.Stop()
-	wg.Done()
-}
-
-type FSEvent struct {
-	Name string
-}
-
 type FWConfig struct {
-	Description string         `yaml:"desc" json:"desc"`
-	Triggers    []TriggerEvent `yaml:"triggers" json:"triggers"`
-	WatchPaths  []string       `yaml:"watch_paths" json:"watch_paths"`
-	WatchDepth  int            `yaml:"watch_depth" json:"watch_depth"`
+	Description string                     `yaml:"desc" json:"desc"`
+	Triggers    []supervisor.TriggerConfig `yaml:"triggers" json:"triggers"`
+	WatchPaths  []string                   `yaml:"watch_paths" json:"watch_paths"`
+	WatchDepth  int                        `yaml:"watch_depth" json:"watch_depth"`
+	Watchers    []WatcherConfig            `yaml:"watchers" json:"watchers"`
 }
 
 func fixFWConfig(in FWConfig) (out FWConfig, err error) {
 	out = in
+	seen := make(map[string]bool, len(in.Triggers))
 	for idx, trigger := range in.Triggers {
-		outTg := &out.Triggers[idx]
-		if trigger.Delay == "" {
-			outTg.Delay = "100ms"
-		}
-		outTg.delayDuration, err = time.ParseDuration(outTg.Delay)
-		if err != nil {
-			return
+		if trigger.Name == "" {
+			trigger.Name = fmt.Sprintf("trigger-%d", idx+1)
 		}
-		if outTg.Signal == "" {
-			outTg.Signal = "HUP"
+		if seen[trigger.Name] {
+			return out, fmt.Errorf("trigger %q: duplicate name, every trigger must have a unique name", trigger.Name)
 		}
-		outTg.killSignal = signalMaps[outTg.Signal]
+		seen[trigger.Name] = true
 
-		rd := ioutil.NopCloser(bytes.NewBufferString(strings.Join(outTg.Pattens, "\n")))
-		patterns, er := ignore.ReadIgnore(rd)
-		if er != nil {
-			err = er
+		out.Triggers[idx], err = supervisor.Fix(trigger)
+		if err != nil {
 			return
 		}
-		outTg.matchPattens = patterns
 	}
 	if len(out.WatchPaths) == 0 {
 		out.WatchPaths = append(out.WatchPaths, ".")
@@ -204,7 +127,7 @@ func genFWConfig() FWConfig {
 	}
 	fwc := FWConfig{
 		Description: fmt.Sprintf("Auto generated by fswatch [%s]", name),
-		Triggers: []TriggerEvent{{
+		Triggers: []supervisor.TriggerConfig{{
 			Pattens: []string{"**/*.go", "**/*.c", "**/*.py"},
 			Environ: map[string]string{
 				"DEBUG": "1",
@@ -253,19 +176,53 @@ func IsDirectory(path string) bool {
 	return err == nil && pinfo.IsDir()
 }
 
-var fileModifyTimeMap = make(map[string]time.Time)
-
-func IsChanged(path string) bool {
-	pinfo, err := os.Stat(path)
-	if err != nil {
-		return true
+// filterTriggers returns the subset of triggers whose Name is in only,
+// or triggers unchanged if only is empty. runFWConfig applies this once
+// up front to honor --only, and reload applies it again to every freshly
+// reloaded config so a live config edit can't silently undo --only by
+// reconciling in triggers the user asked to leave out.
+func filterTriggers(triggers []supervisor.TriggerConfig, only []string) []supervisor.TriggerConfig {
+	if len(only) == 0 {
+		return triggers
 	}
-	mtime := pinfo.ModTime()
-	if mtime.Sub(fileModifyTimeMap[path]) > time.Millisecond*100 { // 100ms
-		fileModifyTimeMap[path] = mtime
-		return true
+	onlySet := make(map[string]bool, len(only))
+	for _, name := range only {
+		onlySet[name] = true
+	}
+	keep := make([]supervisor.TriggerConfig, 0, len(triggers))
+	for _, tg := range triggers {
+		if onlySet[tg.Name] {
+			keep = append(keep, tg)
+		}
+	}
+	return keep
+}
+
+// watchedDirs tracks every directory currently added to the fsnotify
+// watcher so a Remove/Rename of a parent directory can also evict and
+// unwatch its children, instead of leaking them in watchedDirs and the
+// watcher forever.
+var (
+	watchedDirsMu sync.Mutex
+	watchedDirs   = make(map[string]bool)
+)
+
+// evictPath forgets path (and, if it was a directory, everything
+// watched underneath it), removing it from the fsnotify watcher so
+// watchedDirs stays bounded as files/dirs churn.
+func evictPath(w *fsnotify.Watcher, path string) {
+	w.Remove(path)
+
+	watchedDirsMu.Lock()
+	defer watchedDirsMu.Unlock()
+	delete(watchedDirs, path)
+	prefix := path + string(os.PathSeparator)
+	for dir := range watchedDirs {
+		if strings.HasPrefix(dir, prefix) {
+			w.Remove(dir)
+			delete(watchedDirs, dir)
+		}
 	}
-	return false
 }
 
 // visits here for in case of duplicate paths
@@ -281,6 +238,10 @@ func WatchPathAndChildren(w *fsnotify.Watcher, paths []string, depth int, visits
 		w.Add(dir)
 		log.Debug("Watch directory:", dir)
 		visits[dir] = true
+
+		watchedDirsMu.Lock()
+		watchedDirs[dir] = true
+		watchedDirsMu.Unlock()
 	}
 	var err error
 	for _, path := range paths {
@@ -303,34 +264,16 @@ func WatchPathAndChildren(w *fsnotify.Watcher, paths []string, depth int, visits
 	return err
 }
 
-func drainEvent(fwc FWConfig) (globalEventC chan FSEvent, wg *sync.WaitGroup, err error) {
-	globalEventC = make(chan FSEvent, 1)
-	wg = &sync.WaitGroup{}
-	evtChannls := make([]chan FSEvent, 0)
-	// log.Println(len(fwc.Triggers))
-	for _, tg := range fwc.Triggers {
-		wg.Add(1)
-		evtC := make(chan FSEvent, 1)
-		evtChannls = append(evtChannls, evtC)
-		go func(tge TriggerEvent) {
-			tge.WatchEvent(evtC, wg)
-		}(tg)
-
-		// Can't write like this, the next loop tg changed, but go .. is not finished
-		// go tg.WatchEvent(evtC, wg)
-	}
-
-	go func() {
-		for evt := range globalEventC {
-			for _, eC := range evtChannls {
-				eC <- evt
-			}
-		}
-		for _, eC := range evtChannls {
-			close(eC)
+// resolveFWConfigPath returns the first path in paths that exists, so
+// callers that need the config file's own name (e.g. to self-watch it
+// for live reload) don't have to repeat readFWConfig's search order.
+func resolveFWConfigPath(paths ...string) (string, error) {
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
 		}
-	}()
-	return
+	}
+	return "", errors.New("Config file not exists")
 }
 
 func readFWConfig(paths ...string) (fwc FWConfig, err error) {
@@ -361,26 +304,39 @@ func readFWConfig(paths ...string) (fwc FWConfig, err error) {
 	return fwc, errors.New("Config file not exists")
 }
 
-func transformEvent(fsw *fsnotify.Watcher, evtC chan FSEvent) {
+// transformEvent turns raw fsnotify events into supervisor.Changes
+// broadcast to sup. A write/create to configPath (the active
+// .fsw.yml/.fsw.json, if any) is treated specially: it triggers
+// onConfigChange instead of being forwarded to any trigger.
+func transformEvent(fsw *fsnotify.Watcher, sup *supervisor.Supervisor, configPath string, onConfigChange func()) {
 	for evt := range fsw.Events {
-		if evt.Op == fsnotify.Create && IsDirectory(evt.Name) {
-			log.Info("Add watcher", evt.Name)
-			fsw.Add(evt.Name)
+		if configPath != "" && evt.Name == configPath {
+			if evt.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+				onConfigChange()
+			}
 			continue
 		}
-		if evt.Op == fsnotify.Remove {
-			if err := fsw.Remove(evt.Name); err == nil {
-				log.Info("Remove watcher", evt.Name)
-			}
+		if evt.Op&fsnotify.Create != 0 && IsDirectory(evt.Name) {
+			log.Info("Add watcher", evt.Name)
+			fsw.Add(evt.Name)
+			watchedDirsMu.Lock()
+			watchedDirs[evt.Name] = true
+			watchedDirsMu.Unlock()
 			continue
 		}
-		if !IsChanged(evt.Name) {
+		if evt.Op&fsnotify.Remove != 0 || evt.Op&fsnotify.Rename != 0 {
+			// inotify only reports Rename on the source path, even for
+			// cross-directory renames, so there is no destination event
+			// to pick up the watch from; just evict the old one and let
+			// a future Create re-add it if it still lives under a
+			// watched path.
+			evictPath(fsw, evt.Name)
+			log.Info("Remove watcher", evt.Name)
 			continue
 		}
-		//log.Printf("Changed: %s", evt.Name)
-		evtC <- FSEvent{ // may panic here
+		sup.Broadcast(supervisor.Change{
 			Name: evt.Name,
-		}
+		})
 	}
 }
 
@@ -401,55 +357,108 @@ func InitFWConfig() {
 	fmt.Printf("Saved to %s\n", strconv.Quote(cfg))
 }
 
-func main() {
-	version := flag.Bool("version", false, "Show version")
-	flag.Parse()
+// runFWConfig starts the fsnotify watcher for fwc and blocks until every
+// trigger has stopped (on SIGINT/SIGTERM). only, if non-empty, restricts
+// the run to triggers whose Name is in the set; dryRun logs what would
+// run instead of starting any trigger's Command. If configPath is set,
+// it is watched too: editing it re-parses the config and reconciles the
+// running triggers against it without dropping fsnotify subscriptions
+// for unaffected paths. A SIGHUP does the same reload on demand. If
+// ctlAddr is set, a control API listens there for the `fswatch ctl`
+// client and any other remote caller; ctlToken, if set, is required as
+// a Bearer token on every control API request.
+func runFWConfig(fwc FWConfig, configPath string, only []string, dryRun bool, ctlAddr, ctlToken string) error {
+	fwc.Triggers = filterTriggers(fwc.Triggers, only)
+	if dryRun {
+		for _, tg := range fwc.Triggers {
+			CPrintf(CBLUE, "[dry-run] %s: %s (patterns=%v)", tg.Name, tg.Command, tg.Pattens)
+		}
+		return nil
+	}
 
-	if *version {
-		fmt.Println(VERSION)
-		return
+	visits := make(map[string]bool)
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
 	}
 
-	subCmd := flag.Arg(0)
-	var fwc FWConfig
-	var err error
-	if subCmd == "" {
-		fwc, err = readFWConfig(FWCONFIG_JSON, FWCONFIG_YAML)
-		if err == nil {
-			subCmd = "start"
-		} else {
-			subCmd = "init"
+	if err := WatchPathAndChildren(fsw, fwc.WatchPaths, fwc.WatchDepth, visits); err != nil {
+		log.Println(err)
+	}
+	if configPath != "" {
+		if err := fsw.Add(configPath); err != nil {
+			log.Warnf("could not watch %s for live reload: %v", configPath, err)
 		}
 	}
 
-	switch subCmd {
-	case "init":
-		InitFWConfig()
-	case "start":
-		visits := make(map[string]bool)
-		fsw, _ := fsnotify.NewWatcher()
+	sup := supervisor.New()
+	stopStatus := watchStatus(sup, statusR)
+	defer statusR.Close()
+	defer stopStatus()
+
+	for _, tg := range fwc.Triggers {
+		sup.Start(tg)
+	}
+
+	watchersCtx, cancelWatchers := context.WithCancel(context.Background())
+	defer cancelWatchers()
+	startConfigWatchers(watchersCtx, fwc.Watchers, sup)
 
-		err = WatchPathAndChildren(fsw, fwc.WatchPaths, fwc.WatchDepth, visits)
+	if ctlAddr != "" {
+		ctlSrv, err := ctl.NewServer(ctlAddr, ctlToken, sup)
 		if err != nil {
-			log.Println(err)
+			log.Warnf("could not start control API on %s: %v", ctlAddr, err)
+		} else {
+			defer ctlSrv.Close()
+			go ctlSrv.Serve()
+			CPrintf(CBLUE, "control API listening on %s", ctlAddr)
 		}
+	}
 
-		evtC, wg, err := drainEvent(fwc)
+	reload := func() {
+		if configPath == "" {
+			return
+		}
+		newFwc, err := readFWConfig(configPath)
 		if err != nil {
-			log.Fatal(err)
+			log.Warnf("reload %s: %v", configPath, err)
+			return
 		}
-
-		sigOS := make(chan os.Signal, 1)
-		signal.Notify(sigOS, syscall.SIGINT)
-		signal.Notify(sigOS, syscall.SIGTERM)
-
-		go func() {
-			sig := <-sigOS
-			CPrintf(CPURPLE, "Catch signal %v!", sig)
-			close(evtC)
-		}()
-		go transformEvent(fsw, evtC)
-		wg.Wait()
-		CPrintf(CPURPLE, "Kill all running ... Done")
+		CPrintf(CBLUE, "%s changed, reloading triggers", configPath)
+		sup.Reconcile(filterTriggers(newFwc.Triggers, only))
 	}
+
+	sigOS := make(chan os.Signal, 1)
+	signal.Notify(sigOS, syscall.SIGINT)
+	signal.Notify(sigOS, syscall.SIGTERM)
+	sigHUP := make(chan os.Signal, 1)
+	signal.Notify(sigHUP, syscall.SIGHUP)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-sigHUP:
+				reload()
+			}
+		}
+	}()
+	go func() {
+		sig := <-sigOS
+		CPrintf(CPURPLE, "Catch signal %v!", sig)
+		close(stop)
+		sup.StopAll()
+		close(done)
+	}()
+	go transformEvent(fsw, sup, configPath, reload)
+	<-done
+	CPrintf(CPURPLE, "Kill all running ... Done")
+	return nil
+}
+
+func main() {
+	Execute()
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/go-fsnotify/fsnotify"
+
+	"github.com/DKnight1900/fswatch/supervisor"
+)
+
+// TestWatchPathAndChildrenEvictsChurn creates a few thousand files across
+// many directories, churns them with writes/renames/removes, and deletes
+// half the directories outright. It asserts that watchedDirs tracks only
+// the directories still on disk (evictPath's job) and that the event-loop
+// goroutine exits cleanly once stopped, instead of the old design where
+// fileModifyTimeMap and un-evicted watchedDirs entries grew with every
+// path ever seen.
+func TestWatchPathAndChildrenEvictsChurn(t *testing.T) {
+	root := t.TempDir()
+
+	const numDirs = 20
+	const filesPerDir = 150 // 3000 files total
+
+	dirs := make([]string, 0, numDirs)
+	for i := 0; i < numDirs; i++ {
+		dir := filepath.Join(root, fmt.Sprintf("d%d", i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	visits := make(map[string]bool)
+	if err := WatchPathAndChildren(fsw, []string{root}, 5, visits); err != nil {
+		t.Fatal(err)
+	}
+
+	baseGoroutines := runtime.NumGoroutine()
+
+	sup := supervisor.New()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		transformEvent(fsw, sup, "", func() {})
+	}()
+
+	var survivors int
+	for i, dir := range dirs {
+		for j := 0; j < filesPerDir; j++ {
+			name := filepath.Join(dir, fmt.Sprintf("f%d.txt", j))
+			if err := os.WriteFile(name, []byte("x"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			renamed := name + ".renamed"
+			if err := os.Rename(name, renamed); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(renamed); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i%2 == 0 {
+			if err := os.RemoveAll(dir); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			survivors++
+		}
+	}
+
+	// watchedDirs should settle back down to root + the surviving
+	// directories, not the root + every directory ever created.
+	want := survivors + 1
+	deadline := time.Now().Add(5 * time.Second)
+	var got int
+	for time.Now().Before(deadline) {
+		watchedDirsMu.Lock()
+		got = len(watchedDirs)
+		watchedDirsMu.Unlock()
+		if got <= want {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got > want {
+		t.Errorf("watchedDirs leaked entries: got %d, want <= %d", got, want)
+	}
+
+	fsw.Close()
+	<-done
+
+	if n := runtime.NumGoroutine(); n > baseGoroutines+2 {
+		t.Errorf("event loop leaked goroutines: started at %d, ended at %d", baseGoroutines, n)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/containerd/console"
+
+	"github.com/DKnight1900/fswatch/supervisor"
+)
+
+// StatusRenderer shows the supervisor's trigger lifecycle events to
+// the user. It replaces the ad-hoc CPrintf/log calls TriggerEvent used
+// to make directly.
+type StatusRenderer interface {
+	Render(evt supervisor.Event)
+	Close()
+}
+
+// statusR is the active renderer. It defaults to the plain line
+// renderer so there's always something to render to even before
+// start/run pick the real one based on --no-console/--log-format.
+var statusR StatusRenderer = &lineRenderer{out: bufio.NewWriter(os.Stderr)}
+
+// NewStatusRenderer picks the renderer for the requested format and
+// destination: ndjson if logFormat is "json", the plain line renderer
+// if noConsole is set or stderr isn't a terminal, otherwise the live
+// multi-trigger TTY panel.
+func NewStatusRenderer(logFormat string, noConsole bool) StatusRenderer {
+	if logFormat == "json" {
+		return &jsonRenderer{enc: json.NewEncoder(os.Stderr)}
+	}
+	if !noConsole {
+		if _, err := console.ConsoleFromFile(os.Stderr); err == nil {
+			return newConsoleRenderer()
+		}
+	}
+	return &lineRenderer{out: bufio.NewWriter(os.Stderr)}
+}
+
+// watchStatus subscribes to sup and renders every event it publishes
+// until stop is called. stop unsubscribes and waits for every event
+// already queued to be rendered before returning, so the caller can
+// safely close r right after.
+func watchStatus(sup *supervisor.Supervisor, r StatusRenderer) (stop func()) {
+	sub := sup.Subscribe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for evt := range sub {
+			r.Render(evt)
+		}
+	}()
+	return func() {
+		sup.Unsubscribe(sub)
+		<-done
+	}
+}
+
+type jsonRenderer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (r *jsonRenderer) Render(evt supervisor.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.enc.Encode(evt)
+}
+
+func (r *jsonRenderer) Close() {}
+
+type lineRenderer struct {
+	mu  sync.Mutex
+	out *bufio.Writer
+}
+
+func (r *lineRenderer) Render(evt supervisor.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch evt.Kind {
+	case supervisor.EventExit:
+		fmt.Fprintf(r.out, "[%s] exit code=%d %s\n", evt.Trigger, evt.ExitCode, evt.Message)
+	case supervisor.EventMatched:
+		fmt.Fprintf(r.out, "[%s] matched: %s\n", evt.Trigger, evt.File)
+	case supervisor.EventDebounced:
+		fmt.Fprintf(r.out, "[%s] debounced: %s\n", evt.Trigger, evt.File)
+	default:
+		fmt.Fprintf(r.out, "[%s] %s %s\n", evt.Trigger, evt.Kind, evt.Message)
+	}
+	r.out.Flush()
+}
+
+func (r *lineRenderer) Close() {
+	r.out.Flush()
+}
+
+// triggerPanel is one trigger's row in the consoleRenderer's live panel.
+type triggerPanel struct {
+	startedAt   time.Time
+	haveExit    bool
+	lastExit    int
+	lastMatched string
+}
+
+// consoleRenderer redraws a one-line-per-trigger status panel on a TTY
+// every time an event comes in, the way a BuildKit/podman progress bar
+// repaints in place instead of scrolling.
+type consoleRenderer struct {
+	mu     sync.Mutex
+	rows   map[string]*triggerPanel
+	nLines int // lines drawn last time, so we know how far to rewind the cursor
+}
+
+func newConsoleRenderer() *consoleRenderer {
+	return &consoleRenderer{rows: make(map[string]*triggerPanel)}
+}
+
+func (r *consoleRenderer) Render(evt supervisor.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	row, ok := r.rows[evt.Trigger]
+	if !ok {
+		row = &triggerPanel{}
+		r.rows[evt.Trigger] = row
+	}
+	switch evt.Kind {
+	case supervisor.EventStart, supervisor.EventRestart:
+		row.startedAt = evt.Time
+	case supervisor.EventExit:
+		row.haveExit = true
+		row.lastExit = evt.ExitCode
+	case supervisor.EventMatched:
+		row.lastMatched = evt.File
+	}
+	r.draw()
+}
+
+func (r *consoleRenderer) draw() {
+	names := make([]string, 0, len(r.rows))
+	for name := range r.rows {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if r.nLines > 0 {
+		fmt.Fprintf(os.Stderr, "\033[%dA\033[J", r.nLines)
+	}
+	for _, name := range names {
+		row := r.rows[name]
+		exit := "-"
+		if row.haveExit {
+			exit = fmt.Sprintf("%d", row.lastExit)
+		}
+		fmt.Fprintf(os.Stderr, "%-20s elapsed=%-10s exit=%-4s last=%s\n",
+			name, time.Since(row.startedAt).Round(time.Second), exit, row.lastMatched)
+	}
+	r.nLines = len(names)
+}
+
+func (r *consoleRenderer) Close() {}
@@ -0,0 +1,505 @@
+// Package supervisor owns the lifecycle of triggers: parsing their
+// config, starting/stopping the underlying command on a matching
+// change, debouncing bursts, and publishing what happened so callers
+// (the CLI's status renderer, the ctl control API, ...) can watch along
+// without reaching into trigger internals.
+package supervisor
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	ignore "github.com/codeskyblue/dockerignore"
+	"github.com/codeskyblue/kexec"
+)
+
+var signalMaps = map[string]os.Signal{
+	"INT":  syscall.SIGINT,
+	"HUP":  syscall.SIGHUP,
+	"QUIT": syscall.SIGQUIT,
+	"TRAP": syscall.SIGTRAP,
+	"TERM": syscall.SIGTERM,
+	"KILL": syscall.SIGKILL, // kill -9
+}
+
+func init() {
+	for key, val := range signalMaps {
+		signalMaps["SIG"+key] = val
+		signalMaps[fmt.Sprintf("%d", val)] = val
+	}
+}
+
+// TriggerConfig is one trigger's config, as loaded from .fsw.yml/.fsw.json.
+type TriggerConfig struct {
+	Name           string            `yaml:"name" json:"name"`
+	Pattens        []string          `yaml:"pattens" json:"pattens"`
+	matchPattens   []string          `yaml:"-" json:"-"`
+	Environ        map[string]string `yaml:"env" json:"env"`
+	Command        string            `yaml:"cmd" json:"cmd"`
+	Delay          string            `yaml:"delay" json:"delay"`
+	delayDuration  time.Duration     `yaml:"-" json:"-"`
+	Signal         string            `yaml:"signal" json:"signal"`
+	killSignal     os.Signal         `yaml:"-" json:"-"`
+	Watchers       []string          `yaml:"watchers" json:"watchers"`
+	Debounce       string            `yaml:"debounce" json:"debounce"`
+	debounceWindow time.Duration     `yaml:"-" json:"-"`
+	MaxWait        string            `yaml:"max_wait" json:"max_wait"`
+	maxWait        time.Duration     `yaml:"-" json:"-"`
+}
+
+// Fix fills in defaults (delay, signal, debounce/max_wait) and compiles
+// Pattens, returning the ready-to-run config. Call it on every
+// TriggerConfig loaded from YAML/JSON before passing it to Start.
+func Fix(in TriggerConfig) (out TriggerConfig, err error) {
+	out = in
+	if out.Delay == "" {
+		out.Delay = "100ms"
+	}
+	out.delayDuration, err = time.ParseDuration(out.Delay)
+	if err != nil {
+		return
+	}
+	if out.Signal == "" {
+		out.Signal = "HUP"
+	}
+	out.killSignal = signalMaps[out.Signal]
+
+	if out.Debounce == "" {
+		out.Debounce = "300ms"
+	}
+	out.debounceWindow, err = time.ParseDuration(out.Debounce)
+	if err != nil {
+		return
+	}
+	if out.MaxWait == "" {
+		out.MaxWait = "2s"
+	}
+	out.maxWait, err = time.ParseDuration(out.MaxWait)
+	if err != nil {
+		return
+	}
+
+	rd := ioutil.NopCloser(bytes.NewBufferString(strings.Join(out.Pattens, "\n")))
+	patterns, err := ignore.ReadIgnore(rd)
+	if err != nil {
+		return
+	}
+	out.matchPattens = patterns
+	return
+}
+
+// Change is a single filesystem change, or a notification from a
+// non-fs Watcher, to be matched against running triggers. Name holds
+// the changed path for fs events, or an identifying key/URL/signal name
+// for events from a non-fs source; Source is empty for fs events and
+// set to the originating source's name otherwise.
+type Change struct {
+	Name   string
+	Source string
+}
+
+// EventKind enumerates the trigger lifecycle events a Subscribe
+// channel carries.
+type EventKind string
+
+const (
+	EventStart     EventKind = "start"
+	EventExit      EventKind = "exit"
+	EventMatched   EventKind = "matched"
+	EventDebounced EventKind = "debounced"
+	EventRestart   EventKind = "restart"
+)
+
+// Event is one trigger lifecycle event, published on every Subscribe
+// channel.
+type Event struct {
+	Trigger  string    `json:"trigger"`
+	Kind     EventKind `json:"kind"`
+	Message  string    `json:"message,omitempty"`
+	File     string    `json:"file,omitempty"`
+	ExitCode int       `json:"exit_code,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+func stringsContain(ss []string, s string) bool {
+	for _, item := range ss {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// trigger is one running trigger: its config plus the in-flight
+// *kexec.KCommand, if any.
+type trigger struct {
+	cfg  TriggerConfig
+	kcmd *kexec.KCommand
+}
+
+func (t *trigger) matches(ch Change) bool {
+	if ch.Source != "" {
+		return stringsContain(t.cfg.Watchers, ch.Source)
+	}
+	isMatch, err := ignore.Matches(ch.Name, t.cfg.Pattens)
+	if err != nil {
+		log.Printf("supervisor: trigger %q: bad pattern matching %q: %v", t.cfg.Name, ch.Name, err)
+		return false
+	}
+	return isMatch
+}
+
+func (t *trigger) start(pub func(Event)) error {
+	pub(Event{Trigger: t.cfg.Name, Kind: EventStart, Message: t.cfg.Command, Time: time.Now()})
+	startTime := time.Now()
+	cmd := kexec.CommandString(t.cfg.Command)
+	env := os.Environ()
+	for key, val := range t.cfg.Environ {
+		env = append(env, fmt.Sprintf("%s=%s", key, val))
+	}
+	cmd.Env = env
+	t.kcmd = cmd
+	err := cmd.Start()
+	go func() {
+		waitErr := cmd.Wait()
+		pub(Event{
+			Trigger:  t.cfg.Name,
+			Kind:     EventExit,
+			ExitCode: exitCodeOf(waitErr),
+			Message:  fmt.Sprintf("finished in %s", time.Since(startTime)),
+			Time:     time.Now(),
+		})
+	}()
+	return err
+}
+
+func (t *trigger) stop(pub func(Event)) {
+	if t.kcmd == nil {
+		return
+	}
+	if t.kcmd.ProcessState != nil && t.kcmd.ProcessState.Exited() {
+		t.kcmd = nil
+		return
+	}
+	t.kcmd.Terminate(t.cfg.killSignal)
+	pub(Event{Trigger: t.cfg.Name, Kind: EventRestart, Message: fmt.Sprintf("terminated, signal(%v)", t.cfg.killSignal), Time: time.Now()})
+	t.kcmd = nil
+}
+
+// watch runs this trigger's whole lifecycle: start the command, filter
+// and debounce changeC down to this trigger's matches, restart on every
+// coalesced match or on a forceC signal, until changeC is closed. t.kcmd
+// is only ever touched from this goroutine, so RunTrigger goes through
+// forceC instead of calling t.stop/t.start itself.
+func (t *trigger) watch(changeC <-chan Change, forceC <-chan struct{}, pub func(Event), wg *sync.WaitGroup) {
+	defer wg.Done()
+	t.start(pub)
+
+	filtered := make(chan Change, 1)
+	go func() {
+		defer close(filtered)
+		for ch := range changeC {
+			if t.matches(ch) {
+				filtered <- ch
+			}
+		}
+	}()
+	debounced := make(chan Change, 1)
+	go debounce(t.cfg.Name, filtered, t.cfg.debounceWindow, t.cfg.maxWait, debounced, pub)
+
+	for {
+		select {
+		case ch, ok := <-debounced:
+			if !ok {
+				t.stop(pub)
+				return
+			}
+			pub(Event{Trigger: t.cfg.Name, Kind: EventMatched, File: ch.Name, Time: time.Now()})
+			t.stop(pub)
+			time.Sleep(t.cfg.delayDuration)
+			t.start(pub)
+		case <-forceC:
+			pub(Event{Trigger: t.cfg.Name, Kind: EventMatched, Message: "forced via ctl", Time: time.Now()})
+			t.stop(pub)
+			time.Sleep(t.cfg.delayDuration)
+			t.start(pub)
+		}
+	}
+}
+
+// running pairs a live trigger with the channels its changes and forced
+// runs arrive on, so Reconcile can tell a config change from a no-op and
+// Stop can shut a single trigger down independently of the others.
+type running struct {
+	changeC chan Change
+	forceC  chan struct{}
+	tg      *trigger
+}
+
+// Supervisor owns the set of currently running triggers. Triggers can
+// be started, stopped, and reconciled against a reloaded config while
+// watching is in progress; every lifecycle event is published to every
+// Subscribe channel.
+type Supervisor struct {
+	mu    sync.Mutex
+	run   map[string]*running
+	wg    sync.WaitGroup
+	subMu sync.Mutex
+	subs  []chan Event
+}
+
+// New returns an empty Supervisor with no triggers running.
+func New() *Supervisor {
+	return &Supervisor{run: make(map[string]*running)}
+}
+
+// Subscribe returns a channel of every lifecycle event published from
+// here on, until Unsubscribe is called with the same channel. Multiple
+// subscribers (e.g. the CLI's status renderer and a ctl /events
+// client) can watch independently.
+func (s *Supervisor) Subscribe() <-chan Event {
+	ch := make(chan Event, 16)
+	s.subMu.Lock()
+	s.subs = append(s.subs, ch)
+	s.subMu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops publishing to ch and closes it.
+func (s *Supervisor) Unsubscribe(ch <-chan Event) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for i, sub := range s.subs {
+		if sub == ch {
+			s.subs = append(s.subs[:i], s.subs[i+1:]...)
+			close(sub)
+			return
+		}
+	}
+}
+
+func (s *Supervisor) publish(evt Event) {
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for _, sub := range s.subs {
+		select {
+		case sub <- evt:
+		default: // a slow subscriber drops events rather than stalling the supervisor
+		}
+	}
+}
+
+// Start starts cfg (which must already be Fix-ed) as a new trigger.
+func (s *Supervisor) Start(cfg TriggerConfig) {
+	changeC := make(chan Change, 1)
+	forceC := make(chan struct{}, 1)
+	tg := &trigger{cfg: cfg}
+
+	s.mu.Lock()
+	s.run[cfg.Name] = &running{changeC: changeC, forceC: forceC, tg: tg}
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go tg.watch(changeC, forceC, s.publish, &s.wg)
+}
+
+// Stop stops the named trigger and removes it from the running set.
+func (s *Supervisor) Stop(name string) {
+	s.mu.Lock()
+	r, ok := s.run[name]
+	delete(s.run, name)
+	s.mu.Unlock()
+	if ok {
+		close(r.changeC)
+	}
+}
+
+// StopAll stops every running trigger and waits for them to exit.
+func (s *Supervisor) StopAll() {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.run))
+	for name := range s.run {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	for _, name := range names {
+		s.Stop(name)
+	}
+	s.wg.Wait()
+}
+
+// Broadcast fans a Change out to every running trigger, holding s.mu for
+// the whole loop. Stop closes changeC only after removing the trigger
+// from s.run under the same lock, so holding it here is what keeps a
+// send from racing a close; but each send is best-effort (same as
+// publish already does for subscribers), so one trigger backed up on a
+// long delay/restart can't stall the lock, and with it every other
+// Start/Stop/List/RunTrigger/Reconcile call and the fsnotify drain loop
+// feeding Broadcast in the first place.
+func (s *Supervisor) Broadcast(ch Change) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.run {
+		select {
+		case r.changeC <- ch:
+		default: // backed-up trigger drops the change rather than stalling everything else
+		}
+	}
+}
+
+// List returns the config of every currently running trigger.
+func (s *Supervisor) List() []TriggerConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]TriggerConfig, 0, len(s.run))
+	for _, r := range s.run {
+		out = append(out, r.tg.cfg)
+	}
+	return out
+}
+
+// RunTrigger forces the named trigger to stop and restart immediately,
+// bypassing its debounce window. Used by the ctl control API so an
+// editor plugin or CI hook can force a rebuild over IPC. The restart
+// itself happens on the trigger's own watch goroutine, same as a
+// debounced fs match, so it can't race with one.
+func (s *Supervisor) RunTrigger(name string) bool {
+	s.mu.Lock()
+	r, ok := s.run[name]
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case r.forceC <- struct{}{}:
+	default: // a forced run is already queued for this trigger
+	}
+	return true
+}
+
+// Reconcile diffs cfgs (by Name) against the running set: triggers no
+// longer present are stopped, new ones are started, and ones whose
+// config changed are restarted. Unchanged triggers are left running
+// untouched. Each add/remove/restart publishes an Event explaining why,
+// so a config edit's effect shows up in the status renderer and
+// `fswatch ctl events` the same way a trigger's own lifecycle does.
+func (s *Supervisor) Reconcile(cfgs []TriggerConfig) {
+	wanted := make(map[string]TriggerConfig, len(cfgs))
+	for _, cfg := range cfgs {
+		wanted[cfg.Name] = cfg
+	}
+
+	s.mu.Lock()
+	var toStop []string
+	for name := range s.run {
+		if _, ok := wanted[name]; !ok {
+			toStop = append(toStop, name)
+		}
+	}
+	s.mu.Unlock()
+	for _, name := range toStop {
+		s.publish(Event{Trigger: name, Kind: EventRestart, Message: "removed from config, stopping", Time: time.Now()})
+		s.Stop(name)
+	}
+
+	for _, cfg := range cfgs {
+		s.mu.Lock()
+		r, exists := s.run[cfg.Name]
+		s.mu.Unlock()
+		switch {
+		case !exists:
+			s.publish(Event{Trigger: cfg.Name, Kind: EventStart, Message: "added to config, starting", Time: time.Now()})
+			s.Start(cfg)
+		case !configEqual(r.tg.cfg, cfg):
+			s.publish(Event{Trigger: cfg.Name, Kind: EventRestart, Message: "config changed, restarting", Time: time.Now()})
+			s.Stop(cfg.Name)
+			s.Start(cfg)
+		}
+	}
+}
+
+// configEqual reports whether a and b are the same TriggerConfig.
+// Both must already have unexported derived fields populated the same
+// way (i.e. both have gone through Fix), which reconcile guarantees.
+func configEqual(a, b TriggerConfig) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// exitCodeOf extracts the process exit code from the error cmd.Wait()
+// returned, or 0 if it ran to completion successfully.
+func exitCodeOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(interface{ ExitCode() int }); ok {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// debounce coalesces a burst of changes from in into a single flush on
+// out: once a change arrives it waits for window to pass quietly
+// before flushing, but never delays a flush past maxWait since the
+// first change of the burst, so a trigger restarts once per burst of
+// saves instead of once per raw change. Each change dropped because it
+// arrived mid-burst is published as an EventDebounced. Closes out once
+// in is closed.
+func debounce(name string, in <-chan Change, window, maxWait time.Duration, out chan<- Change, pub func(Event)) {
+	defer close(out)
+
+	var (
+		pending  *Change
+		timer    *time.Timer
+		maxTimer *time.Timer
+	)
+	flush := func() {
+		if pending == nil {
+			return
+		}
+		ch := *pending
+		pending = nil
+		timer.Stop()
+		maxTimer.Stop()
+		timer, maxTimer = nil, nil
+		out <- ch
+	}
+
+	for {
+		var timerC, maxTimerC <-chan time.Time
+		if timer != nil {
+			timerC = timer.C
+		}
+		if maxTimer != nil {
+			maxTimerC = maxTimer.C
+		}
+		select {
+		case ch, ok := <-in:
+			if !ok {
+				flush()
+				return
+			}
+			if pending != nil {
+				pub(Event{Trigger: name, Kind: EventDebounced, File: pending.Name, Time: time.Now()})
+			}
+			pending = &ch
+			if timer == nil {
+				maxTimer = time.NewTimer(maxWait)
+			} else {
+				timer.Stop()
+			}
+			timer = time.NewTimer(window)
+		case <-timerC:
+			flush()
+		case <-maxTimerC:
+			flush()
+		}
+	}
+}
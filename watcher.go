@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gobuild/log"
+
+	"github.com/DKnight1900/fswatch/supervisor"
+)
+
+// WatcherConfig describes one non-filesystem event source that can feed
+// into a trigger's event channel alongside (or instead of) fsnotify.
+type WatcherConfig struct {
+	Name     string `yaml:"name" json:"name"`
+	Type     string `yaml:"type" json:"type"` // fs, consul, http, signal
+	Addr     string `yaml:"addr" json:"addr"`
+	Key      string `yaml:"key" json:"key"`
+	URL      string `yaml:"url" json:"url"`
+	Signal   string `yaml:"signal" json:"signal"`
+	Interval string `yaml:"interval" json:"interval"`
+
+	interval time.Duration `yaml:"-" json:"-"`
+}
+
+// Watcher is a pluggable source of supervisor.Change. Implementations wrap
+// Consul KV, a polled HTTP endpoint, or an OS signal, so a trigger can
+// react to "anything changed" rather than only file writes. There is no
+// fsnotify implementation: NewWatcher rejects type fs, and filesystem
+// events come only from the main fsnotify loop.
+type Watcher interface {
+	Run(ctx context.Context) <-chan supervisor.Change
+}
+
+// NewWatcher builds the concrete Watcher for a WatcherConfig.
+func NewWatcher(cfg WatcherConfig) (Watcher, error) {
+	if cfg.Interval == "" {
+		cfg.Interval = "5s"
+	}
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil {
+		return nil, err
+	}
+	cfg.interval = interval
+
+	switch cfg.Type {
+	case "", "fs":
+		return nil, fmt.Errorf("watcher %q: type fs is handled by the main fsnotify loop, not NewWatcher", cfg.Name)
+	case "consul":
+		return &consulWatcher{cfg: cfg}, nil
+	case "http":
+		return &httpWatcher{cfg: cfg}, nil
+	case "signal":
+		return &signalWatcher{cfg: cfg}, nil
+	default:
+		return nil, fmt.Errorf("watcher %q: unknown type %q", cfg.Name, cfg.Type)
+	}
+}
+
+// consulWatcher polls a Consul KV key and emits a Change whenever the
+// value's ModifyIndex changes.
+type consulWatcher struct {
+	cfg WatcherConfig
+}
+
+func (w *consulWatcher) Run(ctx context.Context) <-chan supervisor.Change {
+	evtC := make(chan supervisor.Change, 1)
+	go func() {
+		defer close(evtC)
+		lastIndex := uint64(0)
+		seen := false
+		ticker := time.NewTicker(w.cfg.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				index, changed, err := pollConsulKey(w.cfg.Addr, w.cfg.Key, lastIndex)
+				if err != nil {
+					log.Warnf("consul watcher %q: %v", w.cfg.Name, err)
+					continue
+				}
+				lastIndex = index
+				if changed && seen {
+					evtC <- supervisor.Change{Name: w.cfg.Key, Source: w.cfg.Name}
+				}
+				seen = true
+			}
+		}
+	}()
+	return evtC
+}
+
+// pollConsulKey is a thin wrapper so consulWatcher.Run stays testable
+// without a real Consul agent running. It reports the key's current
+// ModifyIndex and whether it advanced past lastIndex.
+func pollConsulKey(addr, key string, lastIndex uint64) (index uint64, changed bool, err error) {
+	url := fmt.Sprintf("http://%s/v1/kv/%s?index=%d", addr, key, lastIndex)
+	resp, err := http.Get(url)
+	if err != nil {
+		return lastIndex, false, err
+	}
+	defer resp.Body.Close()
+	idx := resp.Header.Get("X-Consul-Index")
+	if idx == "" {
+		return lastIndex, false, nil
+	}
+	var newIndex uint64
+	if _, err := fmt.Sscanf(idx, "%d", &newIndex); err != nil {
+		return lastIndex, false, err
+	}
+	return newIndex, newIndex != lastIndex, nil
+}
+
+// httpWatcher polls a URL and emits a Change whenever the ETag (or, if
+// absent, Last-Modified) response header changes.
+type httpWatcher struct {
+	cfg WatcherConfig
+}
+
+func (w *httpWatcher) Run(ctx context.Context) <-chan supervisor.Change {
+	evtC := make(chan supervisor.Change, 1)
+	go func() {
+		defer close(evtC)
+		lastTag := ""
+		ticker := time.NewTicker(w.cfg.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				tag, err := fetchETag(w.cfg.URL)
+				if err != nil {
+					log.Warnf("http watcher %q: %v", w.cfg.Name, err)
+					continue
+				}
+				if tag != "" && tag != lastTag {
+					if lastTag != "" {
+						evtC <- supervisor.Change{Name: w.cfg.URL, Source: w.cfg.Name}
+					}
+					lastTag = tag
+				}
+			}
+		}
+	}()
+	return evtC
+}
+
+func fetchETag(url string) (string, error) {
+	resp, err := http.Head(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if tag := resp.Header.Get("ETag"); tag != "" {
+		return tag, nil
+	}
+	return resp.Header.Get("Last-Modified"), nil
+}
+
+// signalWatcher emits a Change every time the configured OS signal is
+// received, letting a trigger be forced to re-run out of band (e.g. via
+// `kill -HUP`) without touching any watched path.
+type signalWatcher struct {
+	cfg WatcherConfig
+}
+
+func (w *signalWatcher) Run(ctx context.Context) <-chan supervisor.Change {
+	evtC := make(chan supervisor.Change, 1)
+	sigName := w.cfg.Signal
+	if sigName == "" {
+		sigName = "HUP"
+	}
+	sig, ok := signalMaps[sigName]
+	if !ok {
+		close(evtC)
+		return evtC
+	}
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, sig)
+	go func() {
+		defer close(evtC)
+		defer signal.Stop(sigC)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sigC:
+				evtC <- supervisor.Change{Name: sigName, Source: w.cfg.Name}
+			}
+		}
+	}()
+	return evtC
+}
+
+// startConfigWatchers builds and runs every non-fs WatcherConfig in cfgs,
+// forwarding each Change it emits to sup.Broadcast until ctx is done. A
+// bad WatcherConfig is logged and skipped rather than failing the whole
+// run, since the fs watch (and any other watcher) should keep going.
+func startConfigWatchers(ctx context.Context, cfgs []WatcherConfig, sup *supervisor.Supervisor) {
+	for _, cfg := range cfgs {
+		if cfg.Type == "" || cfg.Type == "fs" {
+			continue // fs sources are handled by the main fsnotify loop
+		}
+		w, err := NewWatcher(cfg)
+		if err != nil {
+			log.Warnf("skipping watcher %q: %v", cfg.Name, err)
+			continue
+		}
+		changeC := w.Run(ctx)
+		go func() {
+			for ch := range changeC {
+				sup.Broadcast(ch)
+			}
+		}()
+	}
+}